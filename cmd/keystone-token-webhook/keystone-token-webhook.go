@@ -0,0 +1,165 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Command keystone-token-webhook runs a standalone TokenReview webhook
+// backed by the Keystone authenticators in
+// plugin/pkg/auth/authenticator/request/keystone. It lets operators run
+// Keystone integration out-of-tree, wired into kube-apiserver via
+// --authentication-token-webhook-config-file, instead of compiling
+// Keystone support into the apiserver itself.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/auth/authenticator"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/auth/user"
+	"github.com/GoogleCloudPlatform/kubernetes/plugin/pkg/auth/authenticator/request/keystone"
+	"github.com/golang/glog"
+)
+
+// basicTokenPrefix marks a TokenReview token as carrying a base64-encoded
+// "username:password" pair rather than a Keystone-issued bearer token,
+// e.g. "basic:dXNlcjpwYXNz". This lets operators who have not yet
+// migrated off password auth use the same webhook.
+const basicTokenPrefix = "basic:"
+
+func main() {
+	tokenURL := flag.String("keystone-token-url", "", "Keystone v3 identity endpoint used to validate bearer tokens presented as X-Subject-Token, e.g. https://example.com:5000/v3")
+	basicAuthURL := flag.String("keystone-basic-auth-url", "", "Keystone identity endpoint used to validate basic:<base64> tokens; defaults to --keystone-token-url")
+	listenAddress := flag.String("listen-address", ":8443", "address the TokenReview webhook listens on")
+	tlsCertFile := flag.String("tls-cert-file", "", "file containing the TLS certificate the webhook presents; required, since kube-apiserver's webhook authenticator only talks HTTPS")
+	tlsKeyFile := flag.String("tls-key-file", "", "file containing the TLS private key matching --tls-cert-file")
+	flag.Parse()
+
+	if *tokenURL == "" {
+		glog.Fatal("--keystone-token-url is required")
+	}
+	if *tlsCertFile == "" || *tlsKeyFile == "" {
+		glog.Fatal("--tls-cert-file and --tls-key-file are required: kube-apiserver's webhook token authenticator requires HTTPS")
+	}
+
+	tokenAuth, err := keystone.NewKeystoneTokenAuthenticator(&keystone.KeystoneTokenAuthenticatorOptions{
+		AuthURL: *tokenURL,
+	})
+	if err != nil {
+		glog.Fatalf("Failed to build keystone token authenticator: %v", err)
+	}
+
+	basicURL := *basicAuthURL
+	if basicURL == "" {
+		basicURL = *tokenURL
+	}
+	basicAuth, err := keystone.NewKeystoneAuthenticator(&keystone.KeystoneAuthenticatorOptions{
+		AuthURL:     basicURL,
+		AuthVersion: keystone.AuthV3,
+	})
+	if err != nil {
+		glog.Fatalf("Failed to build keystone basic-auth authenticator: %v", err)
+	}
+
+	server := &webhookServer{tokenAuth: tokenAuth, basicAuth: basicAuth}
+
+	glog.Infof("Serving keystone TokenReview webhook on %s", *listenAddress)
+	glog.Fatal(http.ListenAndServeTLS(*listenAddress, *tlsCertFile, *tlsKeyFile, server))
+}
+
+// requestAuthenticator is satisfied by *keystone.KeystoneAuthenticator; it
+// exists so tests can substitute a fake instead of dialing a real
+// Keystone server.
+type requestAuthenticator interface {
+	AuthenticateRequest(req *http.Request) (user.Info, bool, error)
+}
+
+// webhookServer implements the TokenReview webhook contract expected by
+// kube-apiserver's --authentication-token-webhook-config-file: it reads
+// a TokenReview from the request body and writes back the same object
+// with Status populated.
+type webhookServer struct {
+	tokenAuth authenticator.Token
+	basicAuth requestAuthenticator
+}
+
+func (s *webhookServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var review tokenReview
+	if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	info, ok, err := s.authenticate(review.Spec.Token)
+
+	review.Status = tokenReviewStatus{Authenticated: ok}
+	if err != nil {
+		review.Status.Error = err.Error()
+	}
+	if ok {
+		review.Status.User = tokenReviewUser{Username: info.GetName(), Groups: info.GetGroups()}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(review)
+}
+
+// authenticate validates token using the token authenticator, unless it
+// carries the basicTokenPrefix, in which case it is decoded and run
+// through the basic-auth authenticator instead.
+func (s *webhookServer) authenticate(token string) (user.Info, bool, error) {
+	if strings.HasPrefix(token, basicTokenPrefix) {
+		req, err := http.NewRequest("GET", "/", nil)
+		if err != nil {
+			return nil, false, err
+		}
+		req.Header.Set("Authorization", "Basic "+strings.TrimPrefix(token, basicTokenPrefix))
+		return s.basicAuth.AuthenticateRequest(req)
+	}
+	return s.tokenAuth.AuthenticateToken(token)
+}
+
+// tokenReview mirrors the shape of the Kubernetes webhook token
+// authentication TokenReview request/response so this binary can be
+// wired up via --authentication-token-webhook-config-file without a
+// dependency on the (not yet vendored, in this tree) authentication API
+// types.
+type tokenReview struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Spec       tokenReviewSpec   `json:"spec,omitempty"`
+	Status     tokenReviewStatus `json:"status,omitempty"`
+}
+
+type tokenReviewSpec struct {
+	Token string `json:"token"`
+}
+
+type tokenReviewStatus struct {
+	Authenticated bool            `json:"authenticated"`
+	User          tokenReviewUser `json:"user,omitempty"`
+	Error         string          `json:"error,omitempty"`
+}
+
+type tokenReviewUser struct {
+	Username string   `json:"username"`
+	Groups   []string `json:"groups,omitempty"`
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/auth/user"
+)
+
+type fakeTokenAuthenticator struct {
+	info user.Info
+	ok   bool
+	err  error
+}
+
+func (f *fakeTokenAuthenticator) AuthenticateToken(token string) (user.Info, bool, error) {
+	return f.info, f.ok, f.err
+}
+
+type fakeRequestAuthenticator struct {
+	info user.Info
+	ok   bool
+	err  error
+}
+
+func (f *fakeRequestAuthenticator) AuthenticateRequest(req *http.Request) (user.Info, bool, error) {
+	return f.info, f.ok, f.err
+}
+
+func TestAuthenticateToken(t *testing.T) {
+	s := &webhookServer{
+		tokenAuth: &fakeTokenAuthenticator{info: &user.DefaultInfo{Name: "alice"}, ok: true},
+		basicAuth: &fakeRequestAuthenticator{err: errors.New("basic auth should not be consulted")},
+	}
+
+	info, ok, err := s.authenticate("some-bearer-token")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ok || info.GetName() != "alice" {
+		t.Errorf("expected authenticated user alice, got ok=%v info=%v", ok, info)
+	}
+}
+
+func TestAuthenticateBasicPrefix(t *testing.T) {
+	s := &webhookServer{
+		tokenAuth: &fakeTokenAuthenticator{err: errors.New("token auth should not be consulted")},
+		basicAuth: &fakeRequestAuthenticator{info: &user.DefaultInfo{Name: "bob"}, ok: true},
+	}
+
+	encoded := base64.StdEncoding.EncodeToString([]byte("bob:password"))
+	info, ok, err := s.authenticate(basicTokenPrefix + encoded)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ok || info.GetName() != "bob" {
+		t.Errorf("expected authenticated user bob, got ok=%v info=%v", ok, info)
+	}
+}
+
+func TestServeHTTPRejectsNonPost(t *testing.T) {
+	s := &webhookServer{}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status %d, got %d", http.StatusMethodNotAllowed, rec.Code)
+	}
+}
+
+func TestServeHTTPRejectsMalformedBody(t *testing.T) {
+	s := &webhookServer{}
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("not json"))
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestServeHTTPAuthenticated(t *testing.T) {
+	s := &webhookServer{
+		tokenAuth: &fakeTokenAuthenticator{info: &user.DefaultInfo{Name: "alice", Groups: []string{"system:masters"}}, ok: true},
+	}
+
+	body := `{"apiVersion":"authentication.k8s.io/v1beta1","kind":"TokenReview","spec":{"token":"good-token"}}`
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), `"authenticated":true`) {
+		t.Errorf("expected authenticated:true in response, got %s", rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), "system:masters") {
+		t.Errorf("expected groups in response, got %s", rec.Body.String())
+	}
+}
+
+func TestServeHTTPUnauthenticated(t *testing.T) {
+	s := &webhookServer{
+		tokenAuth: &fakeTokenAuthenticator{ok: false, err: errors.New("invalid token")},
+	}
+
+	body := `{"spec":{"token":"bad-token"}}`
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), `"authenticated":false`) {
+		t.Errorf("expected authenticated:false in response, got %s", rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), "invalid token") {
+		t.Errorf("expected error message in response, got %s", rec.Body.String())
+	}
+}
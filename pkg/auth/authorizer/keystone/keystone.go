@@ -22,6 +22,7 @@ import (
 	"io"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/GoogleCloudPlatform/kubernetes/pkg/auth/authorizer"
@@ -43,6 +44,28 @@ type osConfig struct {
 	TenantId   string `json:"tenant-id"`
 	TenantName string `json:"tenant-name"`
 	Region     string `json:"region"`
+	// RoleGroupMap maps a Keystone role name (e.g. "admin") to the
+	// Kubernetes group it should confer (e.g. "system:masters"), so
+	// that the standard RBAC authorizer can make the authorization
+	// decision instead of keystoneAuthorizer's legacy all-or-nothing
+	// check.
+	RoleGroupMap map[string]string `json:"role-group-map"`
+	// TrustId, when set, authenticates against Keystone v3 with a token
+	// scoped to this pre-established trust, obtained with Username and
+	// Password, instead of the tenant-scoped password auth.
+	TrustId string `json:"trust-id"`
+	// NotificationListenAddr, when set, is the "host:port" this process
+	// listens on for Keystone user/tenant enable and disable
+	// notifications (delivered directly, or bridged from RabbitMQ/
+	// oslo.messaging), triggering an immediate resync instead of
+	// waiting for the next periodic one. It is a bind address, not a
+	// URL: e.g. "0.0.0.0:9090", not "http://0.0.0.0:9090".
+	NotificationListenAddr string `json:"notification-listen-addr"`
+	// NotificationSharedSecret must be set alongside NotificationListenAddr.
+	// Callers must present it in the X-Keystone-Notification-Secret
+	// header of every POST; it is the only thing standing between the
+	// listener and anyone who can reach the port triggering a resync.
+	NotificationSharedSecret string `json:"notification-shared-secret"`
 }
 
 type OpenstackClient struct {
@@ -52,9 +75,19 @@ type OpenstackClient struct {
 }
 
 type keystoneAuthorizer struct {
-	osClient  Interface
-	userMap   map[string]string
-	tenantMap map[string]string
+	osClient     Interface
+	userMap      map[string]string
+	tenantMap    map[string]string
+	roleGroupMap map[string]string
+	// legacy preserves the historical all-or-nothing behavior, where
+	// holding any role on the tenant is sufficient to authorize every
+	// request. When false, Authorize never grants a request itself; it
+	// always errors so a union.Authorizer chain falls through to the
+	// standard RBAC authorizer. Groups for RBAC are resolved by
+	// GroupsFor, which nothing in this tree calls.
+	legacy bool
+	// metrics tracks the health of the background sync loop.
+	metrics *syncMetrics
 }
 
 func newOpenstackClient(config *osConfig) (*OpenstackClient, error) {
@@ -64,19 +97,28 @@ func newOpenstackClient(config *osConfig) (*OpenstackClient, error) {
 		return nil, err
 	}
 
-	opts := gophercloud.AuthOptions{
-		IdentityEndpoint: config.AuthUrl,
-		Username:         config.Username,
-		Password:         config.Password,
-		TenantID:         config.TenantId,
-		AllowReauth:      true,
-	}
+	var provider *gophercloud.ProviderClient
+	var err error
 
-	provider, err := openstack.AuthenticatedClient(opts)
+	if config.TrustId != "" {
+		provider, err = authenticateTrust(config)
+	} else {
+		opts := gophercloud.AuthOptions{
+			IdentityEndpoint: config.AuthUrl,
+			Username:         config.Username,
+			Password:         config.Password,
+			TenantID:         config.TenantId,
+			AllowReauth:      true,
+		}
+		provider, err = openstack.AuthenticatedClient(opts)
+	}
 	if err != nil {
 		glog.Info("Failed: Starting openstack authenticate client")
 		return nil, err
 	}
+
+	// The same ProviderClient, however it was authenticated, backs the
+	// v2 identity client used by syncUserMap/syncTenantMap/roleCheck.
 	authClient := openstack.NewIdentityV2(provider)
 
 	return &OpenstackClient{
@@ -86,6 +128,29 @@ func newOpenstackClient(config *osConfig) (*OpenstackClient, error) {
 	}, nil
 }
 
+// authenticateTrust authenticates to Keystone v3 with config.Username and
+// config.Password, scoped to the trust identified by config.TrustId.
+func authenticateTrust(config *osConfig) (*gophercloud.ProviderClient, error) {
+	provider, err := openstack.NewClient(config.AuthUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := gophercloud.AuthOptions{
+		IdentityEndpoint: config.AuthUrl,
+		Username:         config.Username,
+		Password:         config.Password,
+		TrustID:          config.TrustId,
+		AllowReauth:      true,
+	}
+
+	if err := openstack.AuthenticateV3(provider, opts, gophercloud.EndpointOpts{}); err != nil {
+		return nil, err
+	}
+
+	return provider, nil
+}
+
 func readConfig(reader io.Reader) (config osConfig, err error) {
 	decoder := json.NewDecoder(reader)
 	err = decoder.Decode(&config)
@@ -95,7 +160,14 @@ func readConfig(reader io.Reader) (config osConfig, err error) {
 	return config, nil
 }
 
-func NewKeystoneAuthorizer(configFile string, period time.Duration) (*keystoneAuthorizer, error) {
+// NewKeystoneAuthorizer builds an authorizer backed by the Keystone
+// config at configFile. Users and tenants are resynced every period
+// seconds, and immediately (cooldown-limited) on each Keystone
+// notification, if openstackConfig.NotificationListenAddr is set. Pass
+// legacy=true to keep the historical all-or-nothing behavior of granting
+// access to any request from a user holding any role on the tenant;
+// otherwise Authorize always defers to RBAC (see its doc comment).
+func NewKeystoneAuthorizer(configFile string, period time.Duration, legacy bool) (*keystoneAuthorizer, error) {
 
 	file, err := os.Open(configFile)
 	if err != nil {
@@ -116,31 +188,35 @@ func NewKeystoneAuthorizer(configFile string, period time.Duration) (*keystoneAu
 	userMap := make(map[string]string)
 
 	ka := &keystoneAuthorizer{
-		osClient:  osClient,
-		tenantMap: tenantMap,
-		userMap:   userMap,
-	}
-
-	if err := ka.syncUserMap(); err != nil {
-		glog.Errorf("Error syncing users: %v", err)
-		return nil, err
+		osClient:     osClient,
+		tenantMap:    tenantMap,
+		userMap:      userMap,
+		roleGroupMap: openstackConfig.RoleGroupMap,
+		legacy:       legacy,
+		metrics:      &syncMetrics{},
 	}
+	activeMetrics.Store(ka.metrics)
 
-	if err := ka.syncTenantMap(); err != nil {
-		glog.Errorf("Error syncing tenants: %v", err)
+	if err := ka.sync(); err != nil {
 		return nil, err
 	}
 
-	go util.Forever(func() {
-		glog.V(4).Info("Syncing users")
-		if err := ka.syncUserMap(); err != nil {
-			glog.Errorf("Error syncing users: %v", err)
+	if openstackConfig.NotificationListenAddr != "" {
+		source, err := newWebhookNotificationSource(openstackConfig.NotificationListenAddr, openstackConfig.NotificationSharedSecret)
+		if err != nil {
+			return nil, err
 		}
-	}, period*time.Second)
+		events, err := source.Watch()
+		if err != nil {
+			return nil, err
+		}
+		go ka.consumeNotifications(events)
+	}
 
 	go util.Forever(func() {
-		if err := ka.syncTenantMap(); err != nil {
-			glog.Errorf("Error syncing tenants: %v", err)
+		glog.V(4).Info("Running periodic full Keystone resync")
+		if err := ka.sync(); err != nil {
+			glog.Errorf("Error syncing keystone users/tenants: %v", err)
 		}
 	}, period*time.Second)
 
@@ -149,23 +225,51 @@ func NewKeystoneAuthorizer(configFile string, period time.Duration) (*keystoneAu
 
 // Authorizer implements authorizer.Authorize
 func (ka *keystoneAuthorizer) Authorize(a authorizer.Attributes) error {
+	if !ka.legacy {
+		// keystoneAuthorizer no longer makes the authorization decision
+		// itself; it must return a non-nil error, with no carve-outs, so
+		// that, when chained through union.Authorizer alongside the
+		// standard RBAC authorizer, the union keeps walking the chain
+		// instead of treating this as an allow. The keystone
+		// authenticators do not call GroupsFor, so no mapped groups are
+		// attached to the user for RBAC to consume.
+		return errors.New("keystone authorization is non-legacy; deferring to RBAC")
+	}
 	if strings.HasPrefix(a.GetUserName(), "system:serviceaccount:") {
 		return nil
 	}
 	if isWhiteListedUser(a.GetUserName()) {
 		return nil
 	}
-	hasRole, err := ka.osClient.roleCheck(ka.userMap[a.GetUserName()], ka.tenantMap[a.GetNamespace()])
+	roles, err := ka.osClient.roleCheck(ka.userMap[a.GetUserName()], ka.tenantMap[a.GetNamespace()])
 	if err != nil {
 		glog.V(4).Infof("Keystone authorization failed: %v", err)
 		return errors.New("Keystone authorization failed")
 	}
-	if hasRole {
+	if len(roles) > 0 {
 		return nil
-	} else {
-		return errors.New("User not authorized through keystone for namespace")
 	}
-	return errors.New("Keystone authorization failed")
+	return errors.New("User not authorized through keystone for namespace")
+}
+
+// GroupsFor resolves the Kubernetes groups a Keystone user should be
+// granted for namespace, by mapping the user's Keystone roles on the
+// corresponding tenant through the configured role-group-map. Roles with
+// no entry in the map are skipped. It does not modify any user.Info; the
+// keystone authenticators do not call it, so the groups it returns reach
+// user.Info.Groups only through a caller that attaches them.
+func (ka *keystoneAuthorizer) GroupsFor(username, namespace string) ([]string, error) {
+	roles, err := ka.osClient.roleCheck(ka.userMap[username], ka.tenantMap[namespace])
+	if err != nil {
+		return nil, err
+	}
+	groups := make([]string, 0, len(roles))
+	for _, role := range roles {
+		if group, ok := ka.roleGroupMap[role]; ok {
+			groups = append(groups, group)
+		}
+	}
+	return groups, nil
 }
 
 func (ka *keystoneAuthorizer) syncTenantMap() error {
@@ -179,6 +283,7 @@ func (ka *keystoneAuthorizer) syncTenantMap() error {
 			tenantMap[tenant.Name] = tenant.ID
 		}
 	}
+	logMapDiff("tenant", ka.tenantMap, tenantMap)
 	ka.tenantMap = tenantMap // The old map is garbage collected as there is no reference to it anymore
 	return nil
 }
@@ -194,66 +299,164 @@ func (ka *keystoneAuthorizer) syncUserMap() error {
 			userMap[user.Username] = user.ID
 		}
 	}
+	logMapDiff("user", ka.userMap, userMap)
 	ka.userMap = userMap // The old map is garbage collected as there is no reference to it anymore
 	return nil
 }
 
-// Checks if a user has access to a tenant
-func (osClient *OpenstackClient) roleCheck(userID string, tenantID string) (bool, error) {
+// logMapDiff logs the names that appeared in or dropped out of a synced
+// user/tenant map, so enable/disable events are visible without diffing
+// the full list by hand.
+func logMapDiff(kind string, old, new map[string]string) {
+	for name := range new {
+		if _, existed := old[name]; !existed {
+			glog.V(4).Infof("Keystone %s enabled: %s", kind, name)
+		}
+	}
+	for name := range old {
+		if _, stillExists := new[name]; !stillExists {
+			glog.V(4).Infof("Keystone %s disabled or removed: %s", kind, name)
+		}
+	}
+}
+
+// roleCheck returns the names of the roles userID holds on tenantID.
+func (osClient *OpenstackClient) roleCheck(userID string, tenantID string) ([]string, error) {
 	if userID == "" {
-		return false, errors.New("UserID null during authorization")
+		return nil, errors.New("UserID null during authorization")
 	}
 	if tenantID == "" {
-		return false, errors.New("UserID null during authorization")
+		return nil, errors.New("TenantID null during authorization")
 	}
-	hasRole := false
+	roleNames := []string{}
 	pager := users.ListRoles(osClient.authClient, tenantID, userID)
 	err := pager.EachPage(func(page pagination.Page) (bool, error) {
 		roleList, err := users.ExtractRoles(page)
 		if err != nil {
 			return false, err
 		}
-		if len(roleList) > 0 {
-			hasRole = true
+		for _, role := range roleList {
+			roleNames = append(roleNames, role.Name)
 		}
 		return true, nil
 	})
 
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-	return hasRole, nil
+	return roleNames, nil
 }
 
-func (osClient *OpenstackClient) getTenants() (tenantList []tenants.Tenant, err error) {
-	tenantList = make([]tenants.Tenant, 0)
-	opts := tenants.ListOpts{}
-	pager := tenants.List(osClient.authClient, &opts)
-	err = pager.EachPage(func(page pagination.Page) (bool, error) {
-		tenantList, err = tenants.ExtractTenants(page)
-		if err != nil {
-			return false, err
+// pageWorkerPoolSize bounds how many pages of a Keystone list response
+// are decoded concurrently. Keystone still hands back pages one at a
+// time (each page's URL comes from the last), so this pipelines page
+// decoding against the next page's fetch rather than reducing the
+// number of round trips; it is what keeps a single sync bounded instead
+// of spinning up a goroutine per page on a large cloud.
+const pageWorkerPoolSize = 4
+
+func (osClient *OpenstackClient) getTenants() ([]tenants.Tenant, error) {
+	type result struct {
+		tenants []tenants.Tenant
+		err     error
+	}
+
+	pages := make(chan pagination.Page)
+	results := make(chan result)
+
+	var workers sync.WaitGroup
+	workers.Add(pageWorkerPoolSize)
+	for i := 0; i < pageWorkerPoolSize; i++ {
+		go func() {
+			defer workers.Done()
+			for page := range pages {
+				extracted, err := tenants.ExtractTenants(page)
+				results <- result{tenants: extracted, err: err}
+			}
+		}()
+	}
+	go func() {
+		workers.Wait()
+		close(results)
+	}()
+
+	go func() {
+		defer close(pages)
+		pager := tenants.List(osClient.authClient, &tenants.ListOpts{})
+		if err := pager.EachPage(func(page pagination.Page) (bool, error) {
+			pages <- page
+			return true, nil
+		}); err != nil {
+			results <- result{err: err}
 		}
-		return true, nil
-	})
-	if err != nil {
-		return nil, err
+	}()
+
+	var tenantList []tenants.Tenant
+	var firstErr error
+	for res := range results {
+		if res.err != nil {
+			if firstErr == nil {
+				firstErr = res.err
+			}
+			continue
+		}
+		tenantList = append(tenantList, res.tenants...)
+	}
+	if firstErr != nil {
+		return nil, firstErr
 	}
 	return tenantList, nil
 }
 
-func (osClient *OpenstackClient) getUsers() (userList []users.User, err error) {
-	userList = make([]users.User, 0)
-	pager := users.List(osClient.authClient)
-	err = pager.EachPage(func(page pagination.Page) (bool, error) {
-		userList, err = users.ExtractUsers(page)
-		if err != nil {
-			return false, err
+func (osClient *OpenstackClient) getUsers() ([]users.User, error) {
+	type result struct {
+		users []users.User
+		err   error
+	}
+
+	pages := make(chan pagination.Page)
+	results := make(chan result)
+
+	var workers sync.WaitGroup
+	workers.Add(pageWorkerPoolSize)
+	for i := 0; i < pageWorkerPoolSize; i++ {
+		go func() {
+			defer workers.Done()
+			for page := range pages {
+				extracted, err := users.ExtractUsers(page)
+				results <- result{users: extracted, err: err}
+			}
+		}()
+	}
+	go func() {
+		workers.Wait()
+		close(results)
+	}()
+
+	go func() {
+		defer close(pages)
+		pager := users.List(osClient.authClient)
+		if err := pager.EachPage(func(page pagination.Page) (bool, error) {
+			pages <- page
+			return true, nil
+		}); err != nil {
+			results <- result{err: err}
 		}
-		return true, nil
-	})
-	if err != nil {
-		return nil, err
+	}()
+
+	var userList []users.User
+	var firstErr error
+	for res := range results {
+		if res.err != nil {
+			if firstErr == nil {
+				firstErr = res.err
+			}
+			continue
+		}
+		userList = append(userList, res.users...)
+	}
+	if firstErr != nil {
+		return nil, firstErr
 	}
 	return userList, nil
 }
@@ -63,13 +63,10 @@ func (osClient *testOpenstackClient) getUsers() (userList []users.User, err erro
 	return getUserSampleSet()
 }
 
-func (osClient *testOpenstackClient) roleCheck(userID string, tenantID string) (bool, error) {
+func (osClient *testOpenstackClient) roleCheck(userID string, tenantID string) ([]string, error) {
 	roleMap := sampleRoleSet()
 	key := tenantID + ":" + userID
-	if len(roleMap[key]) > 0 {
-		return true, nil
-	}
-	return false, nil
+	return roleMap[key], nil
 }
 
 func TestReadConfig(t *testing.T) {
@@ -107,6 +104,24 @@ func TestReadConfig(t *testing.T) {
 	}
 }
 
+func TestReadConfigTrustId(t *testing.T) {
+
+	cfg, err := readConfig(strings.NewReader(`
+{
+  "auth-url": "https://auth-url/v3",
+  "user-name": "username",
+  "password": "password",
+  "trust-id": "8ba909c1e9ac4f8cb1d4e2e6b4b1e2a7"
+}
+`))
+	if err != nil {
+		t.Fatalf("Should succeed when a valid config is provided: %s", err)
+	}
+	if cfg.TrustId != "8ba909c1e9ac4f8cb1d4e2e6b4b1e2a7" {
+		t.Errorf("expected trust id \"8ba909c1e9ac4f8cb1d4e2e6b4b1e2a7\" got %s", cfg.TrustId)
+	}
+}
+
 func TestAuthorize(t *testing.T) {
 
 	testCases := []struct {
@@ -166,6 +181,7 @@ func TestAuthorize(t *testing.T) {
 
 		auth := keystoneAuthorizer{
 			osClient: &testOpenstackClient{},
+			legacy:   true,
 		}
 
 		auth.syncTenantMap()
@@ -183,3 +199,72 @@ func TestAuthorize(t *testing.T) {
 		}
 	}
 }
+
+func TestAuthorizeNonLegacyDefersToRBAC(t *testing.T) {
+	auth := keystoneAuthorizer{
+		osClient: &testOpenstackClient{},
+		legacy:   false,
+	}
+
+	auth.syncTenantMap()
+	auth.syncUserMap()
+
+	attr := authorizer.AttributesRecord{
+		User:      &user.DefaultInfo{Name: "user3"},
+		Namespace: "tenant1",
+	}
+
+	// Non-legacy Authorize must return a non-nil error: keystoneAuthorizer
+	// is meant to be chained through union.Authorizer ahead of the RBAC
+	// authorizer, and union.Authorizer treats the first nil it sees as an
+	// outright allow, short-circuiting RBAC entirely.
+	if err := auth.Authorize(attr); err == nil {
+		t.Errorf("expected non-legacy Authorize to return a non-nil error so union.Authorizer falls through to RBAC")
+	}
+}
+
+func TestAuthorizeNonLegacyIgnoresWhitelist(t *testing.T) {
+	auth := keystoneAuthorizer{
+		osClient: &testOpenstackClient{},
+		legacy:   false,
+	}
+
+	// The serviceaccount/whitelist carve-outs exist for legacy mode only;
+	// non-legacy must defer to RBAC for these usernames too, or RBAC is
+	// silently bypassed for every serviceaccount and control-plane user.
+	usernames := []string{"system:serviceaccount:kube-system:default", "kubelet", "system:scheduler"}
+	for _, username := range usernames {
+		attr := authorizer.AttributesRecord{User: &user.DefaultInfo{Name: username}}
+		if err := auth.Authorize(attr); err == nil {
+			t.Errorf("username %q: expected non-legacy Authorize to return a non-nil error, got nil", username)
+		}
+	}
+}
+
+func TestGroupsFor(t *testing.T) {
+	auth := keystoneAuthorizer{
+		osClient: &testOpenstackClient{},
+		roleGroupMap: map[string]string{
+			"admin": "system:masters",
+			"user":  "keystone:members",
+		},
+	}
+
+	auth.syncTenantMap()
+	auth.syncUserMap()
+
+	groups, err := auth.GroupsFor("user1", "tenant2")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string]bool{"system:masters": true, "keystone:members": true}
+	if len(groups) != len(expected) {
+		t.Fatalf("expected groups %v, got %v", expected, groups)
+	}
+	for _, group := range groups {
+		if !expected[group] {
+			t.Errorf("unexpected group %q", group)
+		}
+	}
+}
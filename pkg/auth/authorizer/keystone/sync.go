@@ -0,0 +1,239 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystone
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// activeMetrics holds the *syncMetrics of the most recently constructed
+// authorizer, so the process-wide sync_lag_seconds GaugeFunc (registered
+// once, in init) always reflects the currently running sync loop.
+var activeMetrics atomic.Value
+
+func init() {
+	activeMetrics.Store(&syncMetrics{})
+
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "keystone_authorizer",
+		Name:      "sync_lag_seconds",
+		Help:      "Seconds since the keystone authorizer last successfully synced users and tenants. Grows continuously while sync is failing.",
+	}, func() float64 {
+		return currentMetrics().SyncLag().Seconds()
+	}))
+	prometheus.MustRegister(syncErrorsTotal)
+}
+
+// currentMetrics returns the *syncMetrics backing the sync_lag_seconds
+// gauge.
+func currentMetrics() *syncMetrics {
+	return activeMetrics.Load().(*syncMetrics)
+}
+
+var syncErrorsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: "keystone_authorizer",
+	Name:      "sync_errors_total",
+	Help:      "Total number of failed keystone user/tenant sync attempts.",
+})
+
+// syncMetrics records the health of the background Keystone sync loop.
+// SyncLag is read on demand by the sync_lag_seconds GaugeFunc, so it
+// keeps growing on its own whenever sync is failing or stalled, instead
+// of freezing at whatever value was last pushed.
+type syncMetrics struct {
+	mu         sync.RWMutex
+	lastSynced time.Time
+	lastErr    error
+}
+
+func (m *syncMetrics) recordSuccess() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastSynced = time.Now()
+	m.lastErr = nil
+}
+
+func (m *syncMetrics) recordError(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastErr = err
+}
+
+// SyncLag returns how long it has been since the last successful sync.
+func (m *syncMetrics) SyncLag() time.Duration {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.lastSynced.IsZero() {
+		return 0
+	}
+	return time.Since(m.lastSynced)
+}
+
+// LastError returns the error from the most recent sync attempt, or nil.
+func (m *syncMetrics) LastError() error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.lastErr
+}
+
+// sync fetches users and tenants concurrently, each through its own
+// pageWorkerPoolSize-bounded worker pool (see getUsers/getTenants),
+// diffs the result against the previous snapshot via
+// syncUserMap/syncTenantMap, and records the outcome in metrics for
+// sync_lag_seconds/sync_errors_total.
+func (ka *keystoneAuthorizer) sync() error {
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		if err := ka.syncUserMap(); err != nil {
+			errs <- fmt.Errorf("syncing users: %v", err)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		if err := ka.syncTenantMap(); err != nil {
+			errs <- fmt.Errorf("syncing tenants: %v", err)
+		}
+	}()
+	wg.Wait()
+	close(errs)
+
+	var err error
+	for e := range errs {
+		err = e
+		glog.Errorf("Keystone sync: %v", e)
+	}
+
+	if err != nil {
+		ka.metrics.recordError(err)
+		syncErrorsTotal.Inc()
+		return err
+	}
+	ka.metrics.recordSuccess()
+	return nil
+}
+
+// notificationResyncCooldown bounds how often a burst of Keystone
+// notifications can trigger a full resync. Each notification still
+// costs the same full getUsers/getTenants round trip as a periodic
+// resync (notifications only carry an enable/disable signal, not the
+// affected entity), so without this cooldown a flapping notification
+// source would multiply load on Keystone rather than reduce it.
+const notificationResyncCooldown = 2 * time.Second
+
+// consumeNotifications triggers a resync for each signal read from
+// events, but coalesces bursts so that resyncs run no more than once
+// per notificationResyncCooldown.
+func (ka *keystoneAuthorizer) consumeNotifications(events <-chan struct{}) {
+	var last time.Time
+	for range events {
+		if since := time.Since(last); since < notificationResyncCooldown {
+			glog.V(4).Infof("Keystone notification received %v after the last resync; coalescing", since)
+			continue
+		}
+		last = time.Now()
+		glog.V(4).Info("Keystone notification received, resyncing")
+		if err := ka.sync(); err != nil {
+			glog.Errorf("Error resyncing after keystone notification: %v", err)
+		}
+	}
+}
+
+// notificationSource delivers a signal whenever Keystone reports a user
+// or tenant enable/disable event, so the authorizer's caches can be
+// invalidated immediately instead of waiting for the next periodic
+// resync.
+type notificationSource interface {
+	// Watch returns a channel that receives a value for every relevant
+	// notification. The channel is closed if the source is stopped.
+	Watch() (<-chan struct{}, error)
+}
+
+// webhookNotificationSource implements notificationSource by listening
+// for HTTP POSTs, so that Keystone (or a RabbitMQ/oslo.messaging-to-HTTP
+// bridge) can push user/tenant enable/disable events as they happen.
+type webhookNotificationSource struct {
+	events       chan struct{}
+	sharedSecret string
+}
+
+// notificationSecretHeader carries the shared secret a caller must
+// present for a POST to be treated as a genuine Keystone notification,
+// rather than an unauthenticated trigger for repeated Keystone round
+// trips from anyone who can reach the listen address.
+const notificationSecretHeader = "X-Keystone-Notification-Secret"
+
+// newWebhookNotificationSource starts listening on addr (host:port) for
+// Keystone notification deliveries. sharedSecret must be non-empty and
+// is compared against the notificationSecretHeader of every POST in
+// constant time; requests that don't match are rejected and never reach
+// source.events.
+func newWebhookNotificationSource(addr, sharedSecret string) (*webhookNotificationSource, error) {
+	if sharedSecret == "" {
+		return nil, errors.New("notification-shared-secret must be set to enable the keystone notification listener")
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	source := &webhookNotificationSource{events: make(chan struct{}, 1), sharedSecret: sharedSecret}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", source.handleNotification)
+
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			glog.Errorf("Keystone notification listener stopped: %v", err)
+		}
+	}()
+
+	return source, nil
+}
+
+// handleNotification rejects any POST whose notificationSecretHeader
+// doesn't match sharedSecret, and otherwise signals events.
+func (s *webhookNotificationSource) handleNotification(w http.ResponseWriter, r *http.Request) {
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get(notificationSecretHeader)), []byte(s.sharedSecret)) != 1 {
+		http.Error(w, "invalid or missing notification secret", http.StatusUnauthorized)
+		return
+	}
+	select {
+	case s.events <- struct{}{}:
+	default:
+		// A resync is already pending; no need to queue another.
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *webhookNotificationSource) Watch() (<-chan struct{}, error) {
+	return s.events, nil
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystone
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rackspace/gophercloud/openstack/identity/v2/users"
+)
+
+func TestKeystoneAuthorizerSync(t *testing.T) {
+	ka := keystoneAuthorizer{
+		osClient: &testOpenstackClient{},
+		metrics:  &syncMetrics{},
+	}
+
+	if err := ka.sync(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(ka.userMap) == 0 || len(ka.tenantMap) == 0 {
+		t.Fatalf("expected sync to populate both maps, got users=%v tenants=%v", ka.userMap, ka.tenantMap)
+	}
+	if ka.metrics.LastError() != nil {
+		t.Errorf("expected no recorded error, got %v", ka.metrics.LastError())
+	}
+}
+
+func TestSyncMetricsRecordsError(t *testing.T) {
+	m := &syncMetrics{}
+	m.recordError(errors.New("boom"))
+	if m.LastError() == nil {
+		t.Errorf("expected recorded error")
+	}
+	if m.SyncLag() != 0 {
+		t.Errorf("expected zero sync lag before any success, got %v", m.SyncLag())
+	}
+}
+
+func TestSyncMetricsLagGrowsDuringOutage(t *testing.T) {
+	m := &syncMetrics{}
+	m.recordSuccess()
+
+	first := m.SyncLag()
+	time.Sleep(5 * time.Millisecond)
+	m.recordError(errors.New("keystone unreachable"))
+	second := m.SyncLag()
+
+	if second <= first {
+		t.Errorf("expected SyncLag to keep growing while sync is failing; first=%v second=%v", first, second)
+	}
+}
+
+// countingOpenstackClient counts how many times getUsers runs, i.e. how
+// many syncs were made.
+type countingOpenstackClient struct {
+	testOpenstackClient
+	userSyncs int
+}
+
+func (c *countingOpenstackClient) getUsers() ([]users.User, error) {
+	c.userSyncs++
+	return c.testOpenstackClient.getUsers()
+}
+
+func TestConsumeNotificationsCoalescesBursts(t *testing.T) {
+	client := &countingOpenstackClient{}
+	ka := &keystoneAuthorizer{
+		osClient: client,
+		metrics:  &syncMetrics{},
+	}
+
+	events := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		ka.consumeNotifications(events)
+		close(done)
+	}()
+
+	// A burst of notifications sent well within notificationResyncCooldown
+	// should result in exactly one resync, not one sync per event.
+	for i := 0; i < 5; i++ {
+		events <- struct{}{}
+	}
+	close(events)
+	<-done
+
+	if len(ka.userMap) == 0 {
+		t.Errorf("expected at least one resync to have run and populated userMap")
+	}
+	if client.userSyncs != 1 {
+		t.Errorf("expected the burst to be coalesced into 1 resync, got %d", client.userSyncs)
+	}
+}
+
+func TestNewWebhookNotificationSourceRequiresSecret(t *testing.T) {
+	if _, err := newWebhookNotificationSource("127.0.0.1:0", ""); err == nil {
+		t.Errorf("expected an empty shared secret to be rejected")
+	}
+}
+
+func TestHandleNotificationRejectsBadSecret(t *testing.T) {
+	source := &webhookNotificationSource{events: make(chan struct{}, 1), sharedSecret: "s3cr3t"}
+
+	req := httptest.NewRequest("POST", "/", nil)
+	rec := httptest.NewRecorder()
+	source.handleNotification(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected missing secret to be rejected with %d, got %d", http.StatusUnauthorized, rec.Code)
+	}
+
+	req.Header.Set(notificationSecretHeader, "wrong")
+	rec = httptest.NewRecorder()
+	source.handleNotification(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected wrong secret to be rejected with %d, got %d", http.StatusUnauthorized, rec.Code)
+	}
+
+	req.Header.Set(notificationSecretHeader, "s3cr3t")
+	rec = httptest.NewRecorder()
+	source.handleNotification(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("expected matching secret to be accepted with %d, got %d", http.StatusNoContent, rec.Code)
+	}
+}
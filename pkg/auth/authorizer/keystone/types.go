@@ -22,7 +22,9 @@ import (
 
 // Interface is an abstract interface for testability.  It abstracts the interface to Keystone.
 type Interface interface {
-	roleCheck(string, string) (bool, error)
+	// roleCheck returns the names of the Keystone roles the user holds
+	// on the tenant, or an empty slice if the user holds none.
+	roleCheck(string, string) ([]string, error)
 	getUsers() ([]users.User, error)
 	getTenants() ([]tenants.Tenant, error)
 }
\ No newline at end of file
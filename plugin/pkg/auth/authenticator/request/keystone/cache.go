@@ -0,0 +1,183 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystone
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/big"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/auth/authenticator"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/auth/user"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+const (
+	// defaultCacheSize bounds the number of distinct credentials cached
+	// in memory at once.
+	defaultCacheSize = 4096
+	// defaultPositiveTTL is how long a successful Keystone validation is
+	// cached before being re-checked.
+	defaultPositiveTTL = 10 * time.Minute
+	// defaultNegativeTTL is how long a failed Keystone validation is
+	// cached before being retried, so that repeated bad credentials
+	// don't hammer Keystone.
+	defaultNegativeTTL = 30 * time.Second
+)
+
+// cacheEntry holds the result of a single Keystone validation.
+type cacheEntry struct {
+	info    user.Info
+	ok      bool
+	err     error
+	expires time.Time
+}
+
+// credentialCache is a bounded, TTL-based cache of resolved Keystone
+// credentials, keyed on a salted hash of the credential so that
+// plaintext passwords and tokens are never held in memory.
+type credentialCache struct {
+	cache          *lru.Cache
+	salt           []byte
+	positiveTTL    time.Duration
+	negativeTTL    time.Duration
+	jitterFraction float64
+}
+
+// newCredentialCache builds a credentialCache, applying defaults for any
+// zero-valued option.
+func newCredentialCache(size int, positiveTTL, negativeTTL time.Duration, jitterFraction float64) (*credentialCache, error) {
+	if size <= 0 {
+		size = defaultCacheSize
+	}
+	if positiveTTL <= 0 {
+		positiveTTL = defaultPositiveTTL
+	}
+	if negativeTTL <= 0 {
+		negativeTTL = defaultNegativeTTL
+	}
+
+	cache, err := lru.New(size)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	return &credentialCache{
+		cache:          cache,
+		salt:           salt,
+		positiveTTL:    positiveTTL,
+		negativeTTL:    negativeTTL,
+		jitterFraction: jitterFraction,
+	}, nil
+}
+
+// key returns a salted hash of credential, never the credential itself.
+func (c *credentialCache) key(credential string) string {
+	mac := hmac.New(sha256.New, c.salt)
+	mac.Write([]byte(credential))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// get returns the cached result for credential, if present and not
+// expired. hit is false when there was no usable cache entry.
+func (c *credentialCache) get(credential string) (info user.Info, ok bool, err error, hit bool) {
+	key := c.key(credential)
+	value, found := c.cache.Get(key)
+	if !found {
+		return nil, false, nil, false
+	}
+	entry := value.(cacheEntry)
+	if time.Now().After(entry.expires) {
+		c.cache.Remove(key)
+		return nil, false, nil, false
+	}
+	return entry.info, entry.ok, entry.err, true
+}
+
+// set caches the result of validating credential, using the positive TTL
+// on success and the (shorter) negative TTL on failure, each jittered to
+// avoid a thundering herd of re-validations on expiry.
+func (c *credentialCache) set(credential string, info user.Info, ok bool, err error) {
+	ttl := c.negativeTTL
+	if ok {
+		ttl = c.positiveTTL
+	}
+	c.cache.Add(c.key(credential), cacheEntry{
+		info:    info,
+		ok:      ok,
+		err:     err,
+		expires: time.Now().Add(jitter(ttl, c.jitterFraction)),
+	})
+}
+
+// jitter randomizes d by up to +/- fraction of its own length.
+func jitter(d time.Duration, fraction float64) time.Duration {
+	if fraction <= 0 {
+		return d
+	}
+	delta := float64(d) * fraction
+	offset, err := rand.Int(rand.Reader, big.NewInt(int64(2*delta)))
+	if err != nil {
+		return d
+	}
+	return d - time.Duration(delta) + time.Duration(offset.Int64())
+}
+
+// cachingPasswordAuthenticator wraps an authenticator.Password with a
+// credentialCache, keyed on "username:password".
+type cachingPasswordAuthenticator struct {
+	delegate authenticator.Password
+	cache    *credentialCache
+}
+
+var _ authenticator.Password = &cachingPasswordAuthenticator{}
+
+func (c *cachingPasswordAuthenticator) AuthenticatePassword(username, password string) (user.Info, bool, error) {
+	credential := username + ":" + password
+	if info, ok, err, hit := c.cache.get(credential); hit {
+		return info, ok, err
+	}
+	info, ok, err := c.delegate.AuthenticatePassword(username, password)
+	c.cache.set(credential, info, ok, err)
+	return info, ok, err
+}
+
+// cachingTokenAuthenticator wraps an authenticator.Token with a
+// credentialCache, keyed on the token itself.
+type cachingTokenAuthenticator struct {
+	delegate authenticator.Token
+	cache    *credentialCache
+}
+
+var _ authenticator.Token = &cachingTokenAuthenticator{}
+
+func (c *cachingTokenAuthenticator) AuthenticateToken(token string) (user.Info, bool, error) {
+	if info, ok, err, hit := c.cache.get(token); hit {
+		return info, ok, err
+	}
+	info, ok, err := c.delegate.AuthenticateToken(token)
+	c.cache.set(token, info, ok, err)
+	return info, ok, err
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystone
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/auth/user"
+)
+
+func TestCredentialCacheRoundTrip(t *testing.T) {
+	cache, err := newCredentialCache(0, time.Minute, time.Millisecond, 0)
+	if err != nil {
+		t.Fatalf("unexpected error building cache: %v", err)
+	}
+
+	if _, _, _, hit := cache.get("user1:password1"); hit {
+		t.Fatalf("expected miss on empty cache")
+	}
+
+	info := &user.DefaultInfo{Name: "user1"}
+	cache.set("user1:password1", info, true, nil)
+
+	gotInfo, ok, err, hit := cache.get("user1:password1")
+	if !hit {
+		t.Fatalf("expected hit after set")
+	}
+	if !ok || err != nil || gotInfo.GetName() != "user1" {
+		t.Errorf("unexpected cached result: info=%v ok=%v err=%v", gotInfo, ok, err)
+	}
+}
+
+func TestCredentialCacheNegativeTTLExpires(t *testing.T) {
+	cache, err := newCredentialCache(0, time.Minute, time.Millisecond, 0)
+	if err != nil {
+		t.Fatalf("unexpected error building cache: %v", err)
+	}
+
+	cache.set("user1:badpassword", nil, false, errors.New("invalid credentials"))
+
+	time.Sleep(5 * time.Millisecond)
+
+	if _, _, _, hit := cache.get("user1:badpassword"); hit {
+		t.Errorf("expected negative cache entry to have expired")
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"errors"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/GoogleCloudPlatform/kubernetes/pkg/auth/authenticator"
 	"github.com/GoogleCloudPlatform/kubernetes/pkg/auth/user"
@@ -29,11 +30,81 @@ import (
 	"github.com/rackspace/gophercloud/openstack"
 )
 
+// AuthVersion selects which Keystone identity API is used to validate
+// credentials.
+type AuthVersion string
+
+const (
+	// AuthV2 authenticates against the Keystone v2.0 identity API and
+	// only supports username/password credentials scoped to a tenant.
+	AuthV2 AuthVersion = "v2"
+	// AuthV3 authenticates against the Keystone v3 identity API and
+	// supports domain-scoped users and project or domain scoped tokens.
+	AuthV3 AuthVersion = "v3"
+)
+
+// defaultDomainSeparator splits a basic-auth username of the form
+// "user<sep>domain" into its user and domain parts when no separator is
+// configured.
+const defaultDomainSeparator = "@"
+
+// KeystoneAuthenticatorOptions configures NewKeystoneAuthenticator.
+type KeystoneAuthenticatorOptions struct {
+	// AuthURL is the Keystone identity endpoint, e.g.
+	// "https://example.com:5000/v3".
+	AuthURL string
+	// AuthVersion selects the identity API used to validate credentials.
+	// Defaults to AuthV2 when empty.
+	AuthVersion AuthVersion
+	// DomainSeparator splits a basic-auth username into user and domain,
+	// e.g. "alice@example" becomes user "alice", domain "example".
+	// Defaults to "@" when empty. Only consulted for AuthV3.
+	DomainSeparator string
+	// Domain is used to scope a v3 user when the presented username
+	// carries no domain component.
+	Domain string
+	// ProjectName, ProjectID and DomainID scope the v3 token to a
+	// project or a domain. At most one of ProjectName/ProjectID should
+	// be set; DomainID scopes to a domain instead of a project.
+	ProjectName string
+	ProjectID   string
+	DomainID    string
+
+	// CacheSize bounds the number of distinct credentials cached in
+	// memory at once. Defaults to defaultCacheSize when zero.
+	CacheSize int
+	// CachePositiveTTL is how long a successful Keystone validation is
+	// cached before being re-checked. Defaults to defaultPositiveTTL
+	// (10 minutes) when zero.
+	CachePositiveTTL time.Duration
+	// CacheNegativeTTL is how long a failed Keystone validation is
+	// cached before being retried. Defaults to defaultNegativeTTL
+	// (30 seconds) when zero.
+	CacheNegativeTTL time.Duration
+	// CacheJitterFraction randomizes each cache entry's TTL by up to
+	// this fraction to avoid a thundering herd of re-validations when
+	// many entries expire at once.
+	CacheJitterFraction float64
+}
+
 type OpenstackClient struct {
-	authURL string
+	authURL         string
+	authVersion     AuthVersion
+	domainSeparator string
+	domain          string
+	projectName     string
+	projectID       string
+	domainID        string
 }
 
 func (osClient *OpenstackClient) AuthenticatePassword(username string, password string) (user.Info, bool, error) {
+	if osClient.authVersion == AuthV3 {
+		return osClient.authenticateV3(username, password)
+	}
+	return osClient.authenticateV2(username, password)
+}
+
+func (osClient *OpenstackClient) authenticateV2(username string, password string) (user.Info, bool, error) {
 	opts := gophercloud.AuthOptions{
 		IdentityEndpoint: osClient.authURL,
 		Username:         username,
@@ -49,20 +120,110 @@ func (osClient *OpenstackClient) AuthenticatePassword(username string, password
 	return &user.DefaultInfo{Name: username}, true, nil
 }
 
+func (osClient *OpenstackClient) authenticateV3(username string, password string) (user.Info, bool, error) {
+	bareUser, domain := splitUserDomain(username, osClient.domainSeparator, osClient.domain)
+
+	opts := gophercloud.AuthOptions{
+		IdentityEndpoint: osClient.authURL,
+		Username:         bareUser,
+		Password:         password,
+		DomainName:       domain,
+		TenantName:       osClient.projectName,
+		TenantID:         osClient.projectID,
+	}
+	if osClient.domainID != "" {
+		opts.DomainID = osClient.domainID
+	}
+
+	provider, err := openstack.NewClient(osClient.authURL)
+	if err == nil {
+		err = openstack.AuthenticateV3(provider, opts, gophercloud.EndpointOpts{})
+	}
+	if err != nil {
+		glog.Info("Failed: Starting openstack v3 authenticate client")
+		return nil, false, errors.New("Failed to authenticate")
+	}
+
+	groups := groupsForV3(domain, osClient.domainID, osClient.projectName, osClient.projectID)
+
+	return &user.DefaultInfo{Name: bareUser, Groups: groups}, true, nil
+}
+
+// groupsForV3 builds the keystone:domain:*/keystone:project:* groups for
+// a v3-authenticated user. domain falls back to domainID when empty, so
+// a user scoped by DomainID alone (no Domain, no "@domain" in the
+// username) still gets a keystone:domain: group; project falls back to
+// projectID the same way.
+func groupsForV3(domain, domainID, project, projectID string) []string {
+	groups := []string{}
+
+	domainGroup := domain
+	if domainGroup == "" {
+		domainGroup = domainID
+	}
+	if domainGroup != "" {
+		groups = append(groups, "keystone:domain:"+domainGroup)
+	}
+
+	projectGroup := project
+	if projectGroup == "" {
+		projectGroup = projectID
+	}
+	if projectGroup != "" {
+		groups = append(groups, "keystone:project:"+projectGroup)
+	}
+
+	return groups
+}
+
+// splitUserDomain splits a basic-auth username of the form
+// "user<sep>domain" into its user and domain components. If username
+// carries no separator, defaultDomain is returned unchanged.
+func splitUserDomain(username, separator, defaultDomain string) (string, string) {
+	if separator == "" {
+		separator = defaultDomainSeparator
+	}
+	if idx := strings.LastIndex(username, separator); idx != -1 {
+		return username[:idx], username[idx+len(separator):]
+	}
+	return username, defaultDomain
+}
+
 type KeystoneAuthenticator struct {
 	osClient authenticator.Password
 }
 
-// New returns a request authenticator that validates credentials using openstack keystone
-func NewKeystoneAuthenticator(authURL string) (*KeystoneAuthenticator, error) {
-	if authURL == "" {
+// NewKeystoneAuthenticator returns a request authenticator that validates
+// credentials using openstack keystone, configured by opts. Validated
+// credentials are cached (see CacheSize/CachePositiveTTL/CacheNegativeTTL)
+// so that not every request incurs a round-trip to Keystone.
+func NewKeystoneAuthenticator(opts *KeystoneAuthenticatorOptions) (*KeystoneAuthenticator, error) {
+	if opts == nil || opts.AuthURL == "" {
 		return nil, errors.New("Auth URL is empty")
 	}
 
-	osClient := OpenstackClient{authURL}
+	authVersion := opts.AuthVersion
+	if authVersion == "" {
+		authVersion = AuthV2
+	}
+
+	osClient := &OpenstackClient{
+		authURL:         opts.AuthURL,
+		authVersion:     authVersion,
+		domainSeparator: opts.DomainSeparator,
+		domain:          opts.Domain,
+		projectName:     opts.ProjectName,
+		projectID:       opts.ProjectID,
+		domainID:        opts.DomainID,
+	}
+
+	cache, err := newCredentialCache(opts.CacheSize, opts.CachePositiveTTL, opts.CacheNegativeTTL, opts.CacheJitterFraction)
+	if err != nil {
+		return nil, err
+	}
 
 	return &KeystoneAuthenticator{
-		osClient: &osClient,
+		osClient: &cachingPasswordAuthenticator{delegate: osClient, cache: cache},
 	}, nil
 }
 
@@ -150,3 +150,74 @@ func TestKeystoneAuth(t *testing.T) {
 		}
 	}
 }
+
+func TestGroupsForV3(t *testing.T) {
+	testCases := []struct {
+		name                string
+		domain, domainID    string
+		project, projectID  string
+		expected            []string
+	}{
+		{
+			name:     "domain and project name",
+			domain:   "example",
+			project:  "myproject",
+			expected: []string{"keystone:domain:example", "keystone:project:myproject"},
+		},
+		{
+			name:     "domainID-scoped login falls back to domainID",
+			domainID: "abc123",
+			project:  "myproject",
+			expected: []string{"keystone:domain:abc123", "keystone:project:myproject"},
+		},
+		{
+			name:      "projectID-scoped login falls back to projectID",
+			domain:    "example",
+			projectID: "def456",
+			expected:  []string{"keystone:domain:example", "keystone:project:def456"},
+		},
+		{
+			name:     "nothing scoped",
+			expected: []string{},
+		},
+	}
+
+	for _, testCase := range testCases {
+		groups := groupsForV3(testCase.domain, testCase.domainID, testCase.project, testCase.projectID)
+		if len(groups) != len(testCase.expected) {
+			t.Errorf("%s: expected groups %v, got %v", testCase.name, testCase.expected, groups)
+			continue
+		}
+		for i, group := range groups {
+			if group != testCase.expected[i] {
+				t.Errorf("%s: expected groups %v, got %v", testCase.name, testCase.expected, groups)
+				break
+			}
+		}
+	}
+}
+
+func TestSplitUserDomain(t *testing.T) {
+	testCases := []struct {
+		username      string
+		separator     string
+		defaultDomain string
+
+		expectedUser   string
+		expectedDomain string
+	}{
+		{username: "alice@example", separator: "", defaultDomain: "", expectedUser: "alice", expectedDomain: "example"},
+		{username: "alice", separator: "", defaultDomain: "default", expectedUser: "alice", expectedDomain: "default"},
+		{username: "alice|example", separator: "|", defaultDomain: "", expectedUser: "alice", expectedDomain: "example"},
+	}
+
+	for _, testCase := range testCases {
+		user, domain := splitUserDomain(testCase.username, testCase.separator, testCase.defaultDomain)
+		if user != testCase.expectedUser {
+			t.Errorf("%s: expected user %q, got %q", testCase.username, testCase.expectedUser, user)
+		}
+		if domain != testCase.expectedDomain {
+			t.Errorf("%s: expected domain %q, got %q", testCase.username, testCase.expectedDomain, domain)
+		}
+	}
+}
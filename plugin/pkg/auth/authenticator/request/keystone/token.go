@@ -0,0 +1,149 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystone
+
+import (
+	"errors"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/auth/authenticator"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/auth/user"
+	"github.com/golang/glog"
+	"github.com/rackspace/gophercloud"
+	"github.com/rackspace/gophercloud/openstack"
+	"github.com/rackspace/gophercloud/openstack/identity/v3/tokens"
+)
+
+// KeystoneTokenAuthenticatorOptions configures NewKeystoneTokenAuthenticator.
+type KeystoneTokenAuthenticatorOptions struct {
+	// AuthURL is the Keystone v3 identity endpoint used to validate
+	// presented tokens, e.g. "https://example.com:5000/v3".
+	AuthURL string
+
+	// CacheSize, CachePositiveTTL, CacheNegativeTTL and
+	// CacheJitterFraction configure the credential cache that sits in
+	// front of Keystone; see KeystoneAuthenticatorOptions for defaults.
+	CacheSize           int
+	CachePositiveTTL    time.Duration
+	CacheNegativeTTL    time.Duration
+	CacheJitterFraction float64
+}
+
+// KeystoneTokenAuthenticator validates bearer tokens issued by Keystone
+// (e.g. via "openstack token issue") against the identity service's
+// /v3/auth/tokens endpoint, so clients can present a token instead of
+// re-sending a username and password on every request.
+type KeystoneTokenAuthenticator struct {
+	delegate authenticator.Token
+}
+
+var _ authenticator.Token = &KeystoneTokenAuthenticator{}
+
+// NewKeystoneTokenAuthenticator returns a token authenticator backed by
+// opts.AuthURL, fronted by a credential cache. cmd/keystone-token-webhook
+// builds one from its --keystone-token-url flag, alongside the basic-auth
+// KeystoneAuthenticator; it is not wired into the apiserver's own token
+// authenticator chain.
+func NewKeystoneTokenAuthenticator(opts *KeystoneTokenAuthenticatorOptions) (*KeystoneTokenAuthenticator, error) {
+	if opts == nil || opts.AuthURL == "" {
+		return nil, errors.New("Auth URL is empty")
+	}
+
+	provider, err := openstack.NewClient(opts.AuthURL)
+	if err != nil {
+		return nil, err
+	}
+
+	validator := &keystoneTokenValidator{
+		identity: openstack.NewIdentityV3(provider),
+	}
+
+	cache, err := newCredentialCache(opts.CacheSize, opts.CachePositiveTTL, opts.CacheNegativeTTL, opts.CacheJitterFraction)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KeystoneTokenAuthenticator{
+		delegate: &cachingTokenAuthenticator{delegate: validator, cache: cache},
+	}, nil
+}
+
+// AuthenticateToken implements authenticator.Token by delegating to the
+// configured (and cached) Keystone token validator.
+func (a *KeystoneTokenAuthenticator) AuthenticateToken(token string) (user.Info, bool, error) {
+	return a.delegate.AuthenticateToken(token)
+}
+
+// keystoneTokenValidator performs the uncached round-trip to Keystone's
+// /v3/auth/tokens endpoint.
+type keystoneTokenValidator struct {
+	identity *gophercloud.ServiceClient
+}
+
+var _ authenticator.Token = &keystoneTokenValidator{}
+
+// AuthenticateToken validates token against Keystone's /v3/auth/tokens
+// endpoint, presenting it as X-Subject-Token, and returns the resolved
+// user with its roles, project and domain attached as groups.
+func (a *keystoneTokenValidator) AuthenticateToken(token string) (user.Info, bool, error) {
+	result := tokens.Get(a.identity, token)
+
+	tokenInfo, err := result.ExtractToken()
+	if err != nil {
+		glog.V(4).Infof("Failed to validate keystone token: %v", err)
+		return nil, false, errors.New("Failed to authenticate")
+	}
+
+	tokenUser, err := result.ExtractUser()
+	if err != nil {
+		glog.V(4).Infof("Failed to extract keystone token user: %v", err)
+		return nil, false, errors.New("Failed to authenticate")
+	}
+
+	roles, err := result.ExtractRoles()
+	if err != nil {
+		glog.V(4).Infof("Failed to extract keystone token roles: %v", err)
+		return nil, false, errors.New("Failed to authenticate")
+	}
+
+	project, _ := result.ExtractProject()
+
+	groups := groupsForToken(roles, project, tokenInfo.Domain)
+
+	return &user.DefaultInfo{Name: tokenUser.Name, Groups: groups}, true, nil
+}
+
+// groupsForToken builds the keystone:role:*/keystone:project:*/
+// keystone:domain:* groups for a token-authenticated user. project may
+// be nil (the token wasn't project-scoped); domain.Name may be empty
+// (the token wasn't domain-scoped).
+func groupsForToken(roles []tokens.Role, project *tokens.Project, domain tokens.Domain) []string {
+	groups := make([]string, 0, len(roles)+2)
+	for _, role := range roles {
+		groups = append(groups, "keystone:role:"+role.Name)
+	}
+
+	if project != nil {
+		groups = append(groups, "keystone:project:"+project.Name)
+	}
+
+	if domain.Name != "" {
+		groups = append(groups, "keystone:domain:"+domain.Name)
+	}
+
+	return groups
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2015 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystone
+
+import (
+	"testing"
+
+	"github.com/rackspace/gophercloud/openstack/identity/v3/tokens"
+)
+
+func TestGroupsForToken(t *testing.T) {
+	roles := []tokens.Role{{Name: "admin"}, {Name: "member"}}
+
+	testCases := []struct {
+		name     string
+		roles    []tokens.Role
+		project  *tokens.Project
+		domain   tokens.Domain
+		expected []string
+	}{
+		{
+			name:     "roles, project and domain scoped",
+			roles:    roles,
+			project:  &tokens.Project{Name: "myproject"},
+			domain:   tokens.Domain{Name: "example"},
+			expected: []string{"keystone:role:admin", "keystone:role:member", "keystone:project:myproject", "keystone:domain:example"},
+		},
+		{
+			name:     "unscoped token",
+			roles:    roles,
+			expected: []string{"keystone:role:admin", "keystone:role:member"},
+		},
+		{
+			name:     "no roles",
+			project:  &tokens.Project{Name: "myproject"},
+			expected: []string{"keystone:project:myproject"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		groups := groupsForToken(testCase.roles, testCase.project, testCase.domain)
+		if len(groups) != len(testCase.expected) {
+			t.Errorf("%s: expected groups %v, got %v", testCase.name, testCase.expected, groups)
+			continue
+		}
+		for i, group := range groups {
+			if group != testCase.expected[i] {
+				t.Errorf("%s: expected groups %v, got %v", testCase.name, testCase.expected, groups)
+				break
+			}
+		}
+	}
+}